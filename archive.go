@@ -0,0 +1,254 @@
+package binclude
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// FileSystemFromZip reads a FileSystem from a zip archive, preserving each
+// entry's Mode and ModTime. Directory entries in the archive become
+// directory entries in the FileSystem; files not explicitly listed as
+// directories get their parent directories synthesized, same as the
+// generator does for a walked tree.
+func FileSystemFromZip(r io.ReaderAt, size int64) (*FileSystem, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	fsys := &FileSystem{Files: Files{}}
+
+	for _, zf := range zr.File {
+		name := cleanArchivePath(zf.Name)
+		if name == "" {
+			continue
+		}
+
+		if zf.FileInfo().IsDir() {
+			fsys.Files[name] = &BincludeFile{
+				Filename: path.Base(name),
+				Mode:     zf.Mode(),
+				ModTime:  zf.Modified,
+			}
+			continue
+		}
+
+		rc, err := zf.Open()
+		if err != nil {
+			return nil, err
+		}
+		content, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		fsys.Files[name] = &BincludeFile{
+			Filename: path.Base(name),
+			Mode:     zf.Mode(),
+			ModTime:  zf.Modified,
+			Content:  content,
+		}
+	}
+
+	addMissingDirs(fsys.Files)
+	fsys.RebuildDirIndex()
+
+	return fsys, nil
+}
+
+// FileSystemFromTar reads a FileSystem from a tar archive, preserving each
+// entry's Mode and ModTime.
+func FileSystemFromTar(r io.Reader) (*FileSystem, error) {
+	tr := tar.NewReader(r)
+
+	fsys := &FileSystem{Files: Files{}}
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		name := cleanArchivePath(hdr.Name)
+		if name == "" {
+			continue
+		}
+
+		if hdr.Typeflag == tar.TypeDir {
+			fsys.Files[name] = &BincludeFile{
+				Filename: path.Base(name),
+				Mode:     hdr.FileInfo().Mode(),
+				ModTime:  hdr.ModTime,
+			}
+			continue
+		}
+
+		content, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+
+		fsys.Files[name] = &BincludeFile{
+			Filename: path.Base(name),
+			Mode:     hdr.FileInfo().Mode(),
+			ModTime:  hdr.ModTime,
+			Content:  content,
+		}
+	}
+
+	addMissingDirs(fsys.Files)
+	fsys.RebuildDirIndex()
+
+	return fsys, nil
+}
+
+// cleanArchivePath turns an archive entry name into the slash-separated,
+// leading-slash-free form used as a Files key.
+func cleanArchivePath(name string) string {
+	name = filepath.ToSlash(name)
+	name = strings.TrimPrefix(name, "./")
+	name = strings.TrimPrefix(name, "/")
+	return path.Clean(name)
+}
+
+// addMissingDirs synthesizes a directory entry for every parent path that
+// isn't already present, the same way a filepath.Walk-backed generator
+// would see it, so archives that only list files still get a complete
+// directory index.
+func addMissingDirs(files Files) {
+	for name := range files {
+		for dir := path.Dir(name); dir != "." && dir != "/"; dir = path.Dir(dir) {
+			if _, ok := files[dir]; ok {
+				break
+			}
+			files[dir] = &BincludeFile{
+				Filename: path.Base(dir),
+				Mode:     os.ModeDir | 0755,
+			}
+		}
+	}
+}
+
+// WriteZip exports the FileSystem as a zip archive, so a bincluded tree can
+// be inspected with a normal zip tool or handed to downstream code as a
+// portable asset bundle.
+func (fsys *FileSystem) WriteZip(w io.Writer) error {
+	zw := zip.NewWriter(w)
+
+	var paths []string
+	for p := range fsys.Files {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	for _, p := range paths {
+		file := fsys.Files[p]
+
+		name := p
+		if file.Mode.IsDir() {
+			name += "/"
+		}
+
+		hdr := &zip.FileHeader{
+			Name:     name,
+			Modified: file.ModTime,
+		}
+		hdr.SetMode(file.Mode)
+
+		fw, err := zw.CreateHeader(hdr)
+		if err != nil {
+			return err
+		}
+
+		if file.Mode.IsDir() {
+			continue
+		}
+
+		content, err := readPlain(fsys, p, file)
+		if err != nil {
+			return err
+		}
+		if _, err := fw.Write(content); err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
+// WriteTar exports the FileSystem as a tar archive.
+func (fsys *FileSystem) WriteTar(w io.Writer) error {
+	tw := tar.NewWriter(w)
+
+	var paths []string
+	for p := range fsys.Files {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	for _, p := range paths {
+		file := fsys.Files[p]
+
+		typeflag := byte(tar.TypeReg)
+		var content []byte
+		if file.Mode.IsDir() {
+			typeflag = tar.TypeDir
+		} else {
+			var err error
+			content, err = readPlain(fsys, p, file)
+			if err != nil {
+				return err
+			}
+		}
+
+		hdr := &tar.Header{
+			Name:     p,
+			Typeflag: typeflag,
+			Mode:     int64(file.Mode.Perm()),
+			Size:     int64(len(content)),
+			ModTime:  file.ModTime,
+		}
+		if file.Mode.IsDir() {
+			hdr.Name += "/"
+			hdr.Size = 0
+		}
+
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !file.Mode.IsDir() {
+			if _, err := tw.Write(content); err != nil {
+				return err
+			}
+		}
+	}
+
+	return tw.Close()
+}
+
+// readPlain returns a file's uncompressed content, decoding it through its
+// Compressor when necessary, without mutating the FileSystem.
+func readPlain(fsys *FileSystem, p string, file *BincludeFile) ([]byte, error) {
+	if file.Compression == None {
+		return file.Content, nil
+	}
+
+	f, err := fsys.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ioutil.ReadAll(f)
+}
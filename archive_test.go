@@ -0,0 +1,88 @@
+package binclude
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func testFileSystemForArchive() *FileSystem {
+	fsys := &FileSystem{Files: Files{
+		"dir/file.txt": {
+			Filename: "file.txt",
+			Mode:     0644,
+			ModTime:  time.Unix(1700000000, 0),
+			Content:  []byte("hello world"),
+		},
+	}}
+	fsys.RebuildDirIndex()
+	return fsys
+}
+
+func TestWriteTarFileSystemFromTarRoundTrip(t *testing.T) {
+	want := testFileSystemForArchive()
+
+	var buf bytes.Buffer
+	if err := want.WriteTar(&buf); err != nil {
+		t.Fatalf("WriteTar: %v", err)
+	}
+
+	got, err := FileSystemFromTar(&buf)
+	if err != nil {
+		t.Fatalf("FileSystemFromTar: %v", err)
+	}
+
+	file, ok := got.Files["dir/file.txt"]
+	if !ok {
+		t.Fatal("dir/file.txt missing after round trip")
+	}
+	if string(file.Content) != "hello world" {
+		t.Errorf("Content = %q, want %q", file.Content, "hello world")
+	}
+	if file.Mode.Perm() != 0644 {
+		t.Errorf("Mode = %v, want 0644", file.Mode.Perm())
+	}
+	if file.ModTime.Unix() != int64(1700000000) {
+		t.Errorf("ModTime = %v, want unix %d", file.ModTime, 1700000000)
+	}
+
+	dir, ok := got.Files["dir"]
+	if !ok {
+		t.Fatal("parent directory \"dir\" was not synthesized")
+	}
+	if !dir.Mode.IsDir() {
+		t.Errorf("\"dir\" Mode = %v, want a directory", dir.Mode)
+	}
+}
+
+func TestWriteZipFileSystemFromZipRoundTrip(t *testing.T) {
+	want := testFileSystemForArchive()
+
+	var buf bytes.Buffer
+	if err := want.WriteZip(&buf); err != nil {
+		t.Fatalf("WriteZip: %v", err)
+	}
+
+	got, err := FileSystemFromZip(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("FileSystemFromZip: %v", err)
+	}
+
+	file, ok := got.Files["dir/file.txt"]
+	if !ok {
+		t.Fatal("dir/file.txt missing after round trip")
+	}
+	if string(file.Content) != "hello world" {
+		t.Errorf("Content = %q, want %q", file.Content, "hello world")
+	}
+	if file.Mode.Perm() != 0644 {
+		t.Errorf("Mode = %v, want 0644", file.Mode.Perm())
+	}
+	if file.ModTime.Unix() != int64(1700000000) {
+		t.Errorf("ModTime = %v, want unix %d", file.ModTime, 1700000000)
+	}
+
+	if _, ok := got.Files["dir"]; !ok {
+		t.Fatal("parent directory \"dir\" was not synthesized")
+	}
+}
@@ -2,12 +2,13 @@ package binclude
 
 import (
 	"bytes"
-	"compress/gzip"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"io/ioutil"
 	"os"
+	"path"
 	"path/filepath"
 	"sort"
 	"strings"
@@ -36,37 +37,88 @@ func IncludeFromFile(name string) {}
 // FileSystem implements access to a collection of named files.
 type FileSystem struct {
 	Files
+	// Dirs maps a directory path to the sorted names of its direct children
+	// (files and subdirectories alike), so Readdir doesn't need to scan all
+	// of Files to answer a single directory listing. Generated code
+	// populates it up front; callers who mutate Files at runtime should
+	// call RebuildDirIndex afterwards.
+	Dirs map[string][]string
 	sync.RWMutex
 }
 
 // Files a map from the filepath to the files
 type Files map[string]*BincludeFile
 
+// RebuildDirIndex recomputes Dirs from Files. Call it after adding or
+// removing entries in Files directly, since Readdir relies on Dirs being
+// up to date.
+func (fsys *FileSystem) RebuildDirIndex() {
+	dirs := make(map[string][]string)
+
+	// Files is keyed by slash-separated io/fs paths, not OS paths, so this
+	// must use the "path" package rather than "path/filepath" - filepath.Dir
+	// and filepath.Base would split on the OS separator and produce keys
+	// that never match on Windows.
+	for p := range fsys.Files {
+		dir := path.Dir(p)
+		dirs[dir] = append(dirs[dir], path.Base(p))
+	}
+
+	for dir := range dirs {
+		sort.Strings(dirs[dir])
+	}
+
+	fsys.Dirs = dirs
+}
+
 // GoString internally used for code generation
-func (fs *FileSystem) GoString() string {
+func (fsys *FileSystem) GoString() string {
 	var b strings.Builder
 	b.WriteString("&binclude.FileSystem{Files: binclude.Files{\n")
 
 	var paths []string
-	for path := range fs.Files {
+	for path := range fsys.Files {
 		paths = append(paths, path)
 	}
 
 	sort.Strings(paths)
 
 	for _, path := range paths {
-		file := fs.Files[path]
+		file := fsys.Files[path]
 		b.WriteString(fmt.Sprintf("%q: %#v,\n", path, file))
 
 	}
 
-	b.WriteString("}}")
+	b.WriteString("},\nDirs: map[string][]string{\n")
+
+	var dirs []string
+	for dir := range fsys.Dirs {
+		dirs = append(dirs, dir)
+	}
+
+	sort.Strings(dirs)
+
+	for _, dir := range dirs {
+		b.WriteString(fmt.Sprintf("%q: %#v,\n", dir, fsys.Dirs[dir]))
+	}
+
+	b.WriteString("},\n}")
 
 	return b.String()
 }
 
-// Open returns a File using the File interface
-func (fs *FileSystem) Open(name string) (File, error) {
+// rootFile stands in for the root directory "." on Open, since the
+// generator only ever walks actual children into Files and never stores an
+// entry for the root itself. io/fs requires a ReadDirFS's Open(".") to
+// succeed (fs.WalkDir, fs.ReadDir and http.FS all start there), so Open
+// falls back to this synthetic directory when no real "." entry exists.
+var rootFile = &BincludeFile{Filename: ".", Mode: os.ModeDir | 0755}
+
+// Open returns a File using the File interface. Each call returns its own
+// handle with its own reader and read position; the *BincludeFile stored in
+// Files is never mutated, so concurrent Opens of the same path (e.g. serving
+// one asset to many clients through http.FS) don't share decode state.
+func (fsys *FileSystem) Open(name string) (fs.File, error) {
 	if Debug {
 		name = filepath.FromSlash(name)
 
@@ -74,20 +126,43 @@ func (fs *FileSystem) Open(name string) (File, error) {
 	}
 
 	name = strings.TrimPrefix(name, "./")
-	if f, ok := fs.Files[name]; ok {
-		f.reader = bytes.NewReader(f.Content)
-		f.path = name
-		f.fs = fs
-		return f, nil
+	if name == "" {
+		name = "."
+	}
+
+	f, ok := fsys.Files[name]
+	if !ok {
+		if name != "." {
+			return nil, &os.PathError{Op: "open", Path: name, Err: errors.New("File does not exist in binclude map")}
+		}
+		f = rootFile
 	}
 
-	return nil, &os.PathError{"open", name, errors.New("File does not exist in binclude map")}
+	of := &openFile{file: f, path: name, fsys: fsys}
+
+	if f.Compression == None {
+		of.reader = bytes.NewReader(f.Content)
+		return of, nil
+	}
+
+	codec, ok := compressors[f.Compression]
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: name, Err: fmt.Errorf("binclude: no compressor registered for id %d", f.Compression)}
+	}
+
+	dec, err := codec.Decode(bytes.NewReader(f.Content))
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+	of.reader = &compressedReader{file: f, codec: codec, dec: dec}
+
+	return of, nil
 }
 
 // Stat returns a FileInfo describing the named file.
 // If there is an error, it will be of type *PathError.
-func (fs *FileSystem) Stat(name string) (os.FileInfo, error) {
-	f, err := fs.Open(name)
+func (fsys *FileSystem) Stat(name string) (fs.FileInfo, error) {
+	f, err := fsys.Open(name)
 	if err != nil {
 		return nil, err
 	}
@@ -100,8 +175,8 @@ func (fs *FileSystem) Stat(name string) (os.FileInfo, error) {
 // A successful call returns err == nil, not err == EOF. Because ReadFile
 // reads the whole file, it does not treat an EOF from Read as an error
 // to be reported.
-func (fs *FileSystem) ReadFile(filename string) ([]byte, error) {
-	f, err := fs.Open(filename)
+func (fsys *FileSystem) ReadFile(filename string) ([]byte, error) {
+	f, err := fsys.Open(filename)
 	if err != nil {
 		return nil, err
 	}
@@ -112,21 +187,28 @@ func (fs *FileSystem) ReadFile(filename string) ([]byte, error) {
 
 // ReadDir reads the directory named by dirname and returns
 // a list of directory entries sorted by filename.
-func (fs *FileSystem) ReadDir(dirname string) ([]os.FileInfo, error) {
-	f, err := fs.Open(dirname)
+// Implements fs.ReadDirFS.
+func (fsys *FileSystem) ReadDir(dirname string) ([]fs.DirEntry, error) {
+	f, err := fsys.Open(dirname)
 	if err != nil {
 		return nil, err
 	}
-	list, _ := f.Readdir(-1)
-	f.Close()
-	sort.Slice(list, func(i, j int) bool { return list[i].Name() < list[j].Name() })
-	return list, nil
+	defer f.Close()
+
+	dir, ok := f.(fs.ReadDirFile)
+	if !ok {
+		return nil, &os.PathError{Op: "readdir", Path: dirname, Err: errors.New("not a directory")}
+	}
+
+	// Dirs already holds each directory's children pre-sorted, so the
+	// listing openFile.ReadDir builds from it is already in filename order.
+	return dir.ReadDir(-1)
 }
 
 // CopyFile copies a specific file from a binclude FileSystem to the hosts FileSystem.
 // Permissions are copied from the included file.
-func (fs *FileSystem) CopyFile(bincludePath, hostPath string) error {
-	src, err := fs.Open(bincludePath)
+func (fsys *FileSystem) CopyFile(bincludePath, hostPath string) error {
+	src, err := fsys.Open(bincludePath)
 	if err != nil {
 		return err
 	}
@@ -153,19 +235,31 @@ func (fs *FileSystem) CopyFile(bincludePath, hostPath string) error {
 	return nil
 }
 
-// Compression the compression algorithm to use
-type Compression int
+// Compression identifies the codec a file was compressed with. It is stored
+// as the codec's registry ID, see RegisterCompressor.
+type Compression uint8
+
+// builtinCompressionNames holds the exported identifier for every
+// compression algorithm shipped with binclude, keyed by its registry ID, so
+// GoString can keep emitting "binclude.Xxx" for them.
+var builtinCompressionNames = map[Compression]string{
+	None:    "None",
+	Gzip:    "Gzip",
+	Zstd:    "Zstd",
+	Brotli:  "Brotli",
+	Deflate: "Deflate",
+}
 
 // GoString internally used for code generation
 func (c Compression) GoString() string {
-	switch c {
-	case None:
-		return "binclude.None"
-	case Gzip:
-		return "binclude.Gzip"
+	if name, ok := builtinCompressionNames[c]; ok {
+		return "binclude." + name
 	}
 
-	panic(fmt.Sprint(int(c), "is not a valid compression algorithm"))
+	// A codec registered by the caller at generation time has no exported
+	// binclude constant; fall back to its stable numeric ID, which Decompress
+	// resolves back through the registry at runtime.
+	return fmt.Sprintf("binclude.Compression(%d)", uint8(c))
 }
 
 const (
@@ -173,66 +267,51 @@ const (
 	None Compression = iota
 	// Gzip use gzip compression
 	Gzip
+	// Zstd use zstd compression
+	Zstd
+	// Brotli use brotli compression
+	Brotli
+	// Deflate use raw deflate compression
+	Deflate
 )
 
-// Decompress turns a FileSystem with compressed files into a filesystem without compressed files
-func (fs *FileSystem) Decompress() (err error) {
-	for path, file := range fs.Files {
+// Decompress turns a FileSystem with compressed files into a filesystem
+// without compressed files, by materializing the plaintext of every
+// compressed file up front. Open already decodes compressed files lazily on
+// read, so Decompress is an opt-in bulk operation for callers that would
+// rather trade memory for CPU.
+func (fsys *FileSystem) Decompress() (err error) {
+	for path, file := range fsys.Files {
 		if file.Compression == None {
 			continue
 		}
 
-		f, _ := fs.Open(path) // open cannot error when using a path we got from the fs
-		defer f.Close()
-
-		var compReader io.Reader
-		if file.Compression == Gzip {
-			compReader, err = gzip.NewReader(f)
-			if err != nil {
-				return fmt.Errorf("Gzip err: %v", err)
-			}
+		// Open decodes on Read; it fails when no codec is registered for the
+		// file's Compression or the decoder rejects the content.
+		f, err := fsys.Open(path)
+		if err != nil {
+			return err
 		}
+		defer f.Close()
 
-		content, err := ioutil.ReadAll(compReader)
+		content, err := ioutil.ReadAll(f)
 		if err != nil {
 			return fmt.Errorf("Reader err: %v", err)
 		}
-		f.Close()
 
-		fs.Files[path].Content = content
+		fsys.Files[path].Content = content
+		fsys.Files[path].Compression = None
 	}
 
 	return nil
 
 }
 
-// Compress turns a FileSystem without compressed files into a filesystem with compressed files
-func (fs *FileSystem) Compress(algo Compression) error {
-	if algo == None {
-		return nil
-	}
-	for _, file := range fs.Files {
-		if file.Mode.IsDir() || !shouldCompress(file.Filename) {
-			continue
-		}
-		var b bytes.Buffer
-
-		var writer io.WriteCloser
-		if algo == Gzip {
-			writer = gzip.NewWriter(&b)
-		}
-
-		_, err := writer.Write(file.Content)
-		writer.Close()
-		if err != nil {
-			return err
-		}
-
-		file.Compression = algo
-		file.Content = b.Bytes()
-	}
-
-	return nil
+// Compress turns a FileSystem without compressed files into a filesystem
+// with compressed files, using sensible defaults. Use CompressWithOptions
+// to control the worker pool, compression level or minimum file size.
+func (fsys *FileSystem) Compress(algo Compression) error {
+	return fsys.CompressWithOptions(algo, CompressOptions{})
 }
 
 // compressExcl exclude certain files from compression which don't compress well
@@ -258,95 +337,200 @@ type File interface {
 	Stat() (os.FileInfo, error)
 }
 
-// BincludeFile implements the io.Reader, io.Seeker, io.Closer and http.File interfaces
+// BincludeFile holds the data for a single bincluded file or directory. It is
+// pure data shared across every Open call for its path; the mutable state
+// for a single open handle (the decode reader, read position, directory
+// cursor) lives on openFile instead, so two concurrent Opens of the same
+// path never alias each other's reader.
 type BincludeFile struct {
 	Filename string
 	Mode     os.FileMode
 	ModTime  time.Time
 	Content  []byte
 	Compression
-	reader io.ReadSeeker
+	// UncompressedSize is the size of Content once decompressed, populated
+	// at generation time so Stat can report it without decoding anything.
+	// Unused when Compression is None, since Content is already plaintext.
+	UncompressedSize int64
+}
+
+// Size returns the uncompressed length of the file, i.e. the number of
+// bytes available for reading, regardless of how big Content is on disk.
+// The returned value is always the same and is not affected by calls
+// to any other method.
+func (f *BincludeFile) Size() int64 {
+	if f.Compression != None {
+		return f.UncompressedSize
+	}
+	return int64(len(f.Content))
+}
+
+// stat builds the FileInfo describing f, given the name it was opened under.
+func (f *BincludeFile) stat(name string) *FileInfo {
+	return &FileInfo{
+		name:    name,
+		mode:    f.Mode,
+		size:    f.Size(),
+		modtime: f.ModTime,
+	}
+}
+
+func (f *BincludeFile) timeString() string {
+	return fmt.Sprint("time.Unix(", f.ModTime.Unix(), ", ", f.ModTime.UnixNano(), ")")
+}
+
+// GoString internally used for code generation
+func (f *BincludeFile) GoString() string {
+	return fmt.Sprintf(`{
+	Filename: %q, Mode: %O, ModTime: %s, Compression: %#v, UncompressedSize: %d,
+Content: []byte(%q),
+}`,
+		f.Filename, f.Mode, f.timeString(), f.Compression, f.UncompressedSize, f.Content)
+}
+
+// openFile is the per-call handle returned by FileSystem.Open. It owns its
+// own reader and directory cursor, so concurrent Opens of the same path
+// (e.g. serving one bincluded asset to many clients through http.FS) don't
+// share mutable state.
+type openFile struct {
+	file   *BincludeFile
 	path   string
-	fs     *FileSystem
+	fsys   *FileSystem
+	reader io.ReadSeeker
+
+	// entries caches the directory listing on first Readdir/ReadDir call;
+	// dirPos is how many of them have already been returned, so repeated
+	// calls stream through the directory instead of replaying it.
+	entries []os.FileInfo
+	dirPos  int
 }
 
 // check that the File interface is implemented
-var _ File = new(BincludeFile)
+var _ File = new(openFile)
+
+// check that openFile also satisfies the standard io/fs interfaces
+var (
+	_ fs.File        = new(openFile)
+	_ fs.ReadDirFile = new(openFile)
+)
 
 // Read implements the io.Reader interface.
-func (f *BincludeFile) Read(p []byte) (n int, err error) {
+func (f *openFile) Read(p []byte) (n int, err error) {
 	return f.reader.Read(p)
 }
 
 // Name returns the name of the file as presented to Open.
-func (f *BincludeFile) Name() string {
+func (f *openFile) Name() string {
 	return f.path
 }
 
 // Close closes the File, rendering it unusable for I/O.
-func (f *BincludeFile) Close() error {
+func (f *openFile) Close() error {
+	if c, ok := f.reader.(io.Closer); ok {
+		c.Close()
+	}
 	f.reader = nil
 	return nil
 }
 
-// Size returns the original length of the underlying byte slice.
-// Size is the number of bytes available for reading via ReadAt.
-// The returned value is always the same and is not affected by calls
-// to any other method.
-func (f *BincludeFile) Size() int64 {
-	return int64(len(f.Content))
-}
+// direntries lazily computes and caches the sorted listing of the directory
+// this handle was opened on, or of the file's parent directory when the
+// handle is a file.
+func (f *openFile) direntries() ([]os.FileInfo, error) {
+	if f.entries != nil {
+		return f.entries, nil
+	}
 
-// Readdir reads the contents of the directory associated with file and
-// returns a slice of up to n FileInfo values, as would be returned
-// by Lstat, in directory order. Subsequent calls on the same file will yield
-// further FileInfos.
-func (f *BincludeFile) Readdir(count int) (infos []os.FileInfo, err error) {
-	fileDir := f.Name()
-	if !f.Mode.IsDir() {
-		fileDir = filepath.Dir(f.path)
+	fileDir := f.path
+	if !f.file.Mode.IsDir() {
+		fileDir = path.Dir(f.path)
+	}
+
+	// An empty Dirs means the index was never built - either a fresh
+	// FileSystem whose generator/loader forgot to, or one deserialized from
+	// a GoString that always emits the (possibly empty) map literal. Rebuild
+	// once so Readdir self-heals instead of silently reporting no entries.
+	if len(f.fsys.Dirs) == 0 {
+		f.fsys.RebuildDirIndex()
 	}
 
-	for path, file := range *&f.fs.Files {
-		if filepath.Dir(path) != fileDir {
+	names := f.fsys.Dirs[fileDir]
+	infos := make([]os.FileInfo, 0, len(names))
+	for _, name := range names {
+		childPath := path.Join(fileDir, name)
+		file, ok := f.fsys.Files[childPath]
+		if !ok {
 			continue
 		}
 
-		info, _ := file.Stat()
-
-		infos = append(infos, info)
+		infos = append(infos, file.stat(file.Filename))
 	}
 
+	f.entries = infos
 	return infos, nil
 }
 
-// Stat returns the FileInfo structure describing file.
-// Error is always nil
-func (f *BincludeFile) Stat() (os.FileInfo, error) {
-	return &FileInfo{
-		name:    f.Filename,
-		mode:    f.Mode,
-		size:    f.Size(),
-		modtime: f.ModTime,
-	}, nil
+// Readdir reads the contents of the directory associated with file and
+// returns a slice of up to count FileInfo values, as would be returned by
+// Lstat, in directory order. If count <= 0, Readdir returns the remaining
+// entries in a single slice. If count > 0, Readdir returns at most count
+// entries and remembers its position, so repeated calls walk the directory
+// and return io.EOF once it's exhausted.
+func (f *openFile) Readdir(count int) ([]os.FileInfo, error) {
+	infos, err := f.direntries()
+	if err != nil {
+		return nil, err
+	}
+
+	if count <= 0 {
+		rest := infos[f.dirPos:]
+		f.dirPos = len(infos)
+		return rest, nil
+	}
+
+	if f.dirPos >= len(infos) {
+		return nil, io.EOF
+	}
+
+	end := f.dirPos + count
+	if end > len(infos) {
+		end = len(infos)
+	}
+	rest := infos[f.dirPos:end]
+	f.dirPos = end
+
+	return rest, nil
 }
 
-// Seek implements the io.Seeker interface.
-func (f *BincludeFile) Seek(offset int64, whence int) (int64, error) {
-	return f.reader.Seek(offset, whence)
+// ReadDir reads the contents of the directory associated with file and
+// returns a slice of up to n DirEntry values, in directory order. If n <= 0,
+// ReadDir returns the remaining entries in a single slice. If n > 0, ReadDir
+// returns at most n entries and remembers its position, so repeated calls
+// walk the directory and return io.EOF once it's exhausted. Implements
+// fs.ReadDirFile.
+func (f *openFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	infos, err := f.Readdir(n)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]fs.DirEntry, len(infos))
+	for i, info := range infos {
+		entries[i] = dirEntry{info.(*FileInfo)}
+	}
+
+	return entries, nil
 }
 
-func (f *BincludeFile) timeString() string {
-	return fmt.Sprint("time.Unix(", f.ModTime.Unix(), ", ", f.ModTime.UnixNano(), ")")
+// Stat returns the FileInfo structure describing file.
+// Error is always nil
+func (f *openFile) Stat() (os.FileInfo, error) {
+	return f.file.stat(f.file.Filename), nil
 }
 
-// GoString internally used for code generation
-func (f *BincludeFile) GoString() string {
-	return fmt.Sprintf(`{
-	Filename: %q, Mode: %O, ModTime: %s, Compression: %#v, 
-Content: []byte(%q),
-}`,
-		f.Filename, f.Mode, f.timeString(), f.Compression, f.Content)
+// Seek implements the io.Seeker interface.
+func (f *openFile) Seek(offset int64, whence int) (int64, error) {
+	return f.reader.Seek(offset, whence)
 }
 
 // FileInfo implements the os.FileInfo interface.
@@ -389,3 +573,22 @@ func (info *FileInfo) IsDir() bool {
 func (info *FileInfo) Sys() interface{} {
 	return nil
 }
+
+// dirEntry adapts a *FileInfo to the fs.DirEntry interface so it can be
+// returned from ReadDir without a second stat.
+type dirEntry struct {
+	*FileInfo
+}
+
+// check that the fs.DirEntry interface is implemented
+var _ fs.DirEntry = dirEntry{}
+
+// Type returns the type bits of the file mode.
+func (d dirEntry) Type() fs.FileMode {
+	return d.Mode().Type()
+}
+
+// Info returns the FileInfo for the entry, never returning an error.
+func (d dirEntry) Info() (fs.FileInfo, error) {
+	return d.FileInfo, nil
+}
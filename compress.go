@@ -0,0 +1,125 @@
+package binclude
+
+import (
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/flate"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compressor implements a single compression codec. Encode wraps w so that
+// bytes written to the returned writer are compressed before reaching w,
+// Decode wraps r so that bytes read from the returned reader are the
+// decompressed form of r. ID is the stable byte stored in the generated
+// source (see Compression.GoString) and must never change once a codec has
+// shipped, since old generated code needs to keep resolving to it.
+type Compressor interface {
+	Encode(w io.Writer) (io.WriteCloser, error)
+	Decode(r io.Reader) (io.ReadCloser, error)
+	ID() uint8
+	Name() string
+}
+
+// compressors holds every codec available to Compress/Decompress, keyed by
+// its Compressor.ID(). Populated by the built-in codecs below and by
+// RegisterCompressor.
+var compressors = map[Compression]Compressor{}
+
+// RegisterCompressor makes a Compressor available to Compress and Decompress
+// under its own ID, overwriting any codec previously registered under that
+// ID. Call it from an init func before generating or loading a FileSystem
+// that uses the codec.
+func RegisterCompressor(c Compressor) {
+	compressors[Compression(c.ID())] = c
+}
+
+func init() {
+	RegisterCompressor(gzipCompressor{})
+	RegisterCompressor(zstdCompressor{})
+	RegisterCompressor(brotliCompressor{})
+	RegisterCompressor(deflateCompressor{})
+}
+
+// gzipCompressor implements Compressor using the standard library's gzip package.
+type gzipCompressor struct{}
+
+func (gzipCompressor) ID() uint8    { return uint8(Gzip) }
+func (gzipCompressor) Name() string { return "gzip" }
+
+func (gzipCompressor) Encode(w io.Writer) (io.WriteCloser, error) {
+	return gzip.NewWriter(w), nil
+}
+
+func (gzipCompressor) EncodeLevel(w io.Writer, level int) (io.WriteCloser, error) {
+	return gzip.NewWriterLevel(w, level)
+}
+
+func (gzipCompressor) Decode(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+// zstdCompressor implements Compressor using klauspost/compress/zstd, which
+// gives a notably better ratio and much faster decode than gzip for typical
+// embedded assets.
+type zstdCompressor struct{}
+
+func (zstdCompressor) ID() uint8    { return uint8(Zstd) }
+func (zstdCompressor) Name() string { return "zstd" }
+
+func (zstdCompressor) Encode(w io.Writer) (io.WriteCloser, error) {
+	return zstd.NewWriter(w)
+}
+
+func (zstdCompressor) EncodeLevel(w io.Writer, level int) (io.WriteCloser, error) {
+	return zstd.NewWriter(w, zstd.WithEncoderLevel(zstd.EncoderLevel(level)))
+}
+
+func (zstdCompressor) Decode(r io.Reader) (io.ReadCloser, error) {
+	dec, err := zstd.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return dec.IOReadCloser(), nil
+}
+
+// brotliCompressor implements Compressor using andybalholm/brotli, the
+// compression backend klauspost/compress itself defers to for brotli.
+type brotliCompressor struct{}
+
+func (brotliCompressor) ID() uint8    { return uint8(Brotli) }
+func (brotliCompressor) Name() string { return "brotli" }
+
+func (brotliCompressor) Encode(w io.Writer) (io.WriteCloser, error) {
+	return brotli.NewWriter(w), nil
+}
+
+func (brotliCompressor) EncodeLevel(w io.Writer, level int) (io.WriteCloser, error) {
+	return brotli.NewWriterLevel(w, level), nil
+}
+
+func (brotliCompressor) Decode(r io.Reader) (io.ReadCloser, error) {
+	return ioutil.NopCloser(brotli.NewReader(r)), nil
+}
+
+// deflateCompressor implements Compressor using raw (headerless) deflate via
+// klauspost/compress/flate, useful when the caller wants gzip-grade ratio
+// without the gzip container overhead.
+type deflateCompressor struct{}
+
+func (deflateCompressor) ID() uint8    { return uint8(Deflate) }
+func (deflateCompressor) Name() string { return "deflate" }
+
+func (deflateCompressor) Encode(w io.Writer) (io.WriteCloser, error) {
+	return flate.NewWriter(w, flate.DefaultCompression)
+}
+
+func (deflateCompressor) EncodeLevel(w io.Writer, level int) (io.WriteCloser, error) {
+	return flate.NewWriter(w, level)
+}
+
+func (deflateCompressor) Decode(r io.Reader) (io.ReadCloser, error) {
+	return flate.NewReader(r), nil
+}
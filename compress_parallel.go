@@ -0,0 +1,137 @@
+package binclude
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"runtime"
+	"sort"
+	"sync"
+)
+
+// LevelCompressor is implemented by codecs whose encoder accepts a
+// compression level. CompressWithOptions uses it when CompressOptions.Level is set
+// and falls back to the codec's own default level otherwise.
+type LevelCompressor interface {
+	Compressor
+	EncodeLevel(w io.Writer, level int) (io.WriteCloser, error)
+}
+
+// CompressOptions configures FileSystem.CompressWithOptions.
+type CompressOptions struct {
+	// Concurrency is the number of goroutines compressing files in
+	// parallel. Zero means runtime.GOMAXPROCS(0).
+	Concurrency int
+	// Level is passed to codecs that implement LevelCompressor. Zero means
+	// the codec's own default level.
+	Level int
+	// MinSize skips files smaller than this many bytes, since compression
+	// overhead dominates for small payloads.
+	MinSize int64
+}
+
+// CompressWithOptions is like Compress but lets the caller tune the worker
+// pool, the compression level and the minimum file size worth compressing.
+func (fsys *FileSystem) CompressWithOptions(algo Compression, opts CompressOptions) error {
+	if algo == None {
+		return nil
+	}
+
+	codec, ok := compressors[algo]
+	if !ok {
+		return fmt.Errorf("binclude: no compressor registered for id %d", algo)
+	}
+
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = runtime.GOMAXPROCS(0)
+	}
+
+	var files []*BincludeFile
+	for _, file := range fsys.Files {
+		if file.Mode.IsDir() || !shouldCompress(file.Filename) {
+			continue
+		}
+		if int64(len(file.Content)) < opts.MinSize {
+			continue
+		}
+		files = append(files, file)
+	}
+
+	// Largest files first (longest-processing-time scheduling) so they
+	// start compressing as early as possible instead of trailing the batch.
+	sort.Slice(files, func(i, j int) bool { return len(files[i].Content) > len(files[j].Content) })
+
+	type result struct {
+		file             *BincludeFile
+		content          []byte
+		uncompressedSize int64
+		err              error
+	}
+
+	work := make(chan *BincludeFile)
+	results := make(chan result)
+
+	var wg sync.WaitGroup
+	wg.Add(concurrency)
+	for i := 0; i < concurrency; i++ {
+		go func() {
+			defer wg.Done()
+			for file := range work {
+				content, err := encode(codec, opts.Level, file.Content)
+				results <- result{file: file, content: content, uncompressedSize: int64(len(file.Content)), err: err}
+			}
+		}()
+	}
+
+	go func() {
+		for _, file := range files {
+			work <- file
+		}
+		close(work)
+		wg.Wait()
+		close(results)
+	}()
+
+	var firstErr error
+	for res := range results {
+		if res.err != nil {
+			if firstErr == nil {
+				firstErr = res.err
+			}
+			continue
+		}
+		res.file.Compression = algo
+		res.file.UncompressedSize = res.uncompressedSize
+		res.file.Content = res.content
+	}
+
+	return firstErr
+}
+
+// encode compresses content with codec, using level when codec supports it.
+// Each call gets its own buffer, so it is safe to run concurrently.
+func encode(codec Compressor, level int, content []byte) ([]byte, error) {
+	var b bytes.Buffer
+
+	var writer io.WriteCloser
+	var err error
+	if lc, ok := codec.(LevelCompressor); ok && level != 0 {
+		writer, err = lc.EncodeLevel(&b, level)
+	} else {
+		writer, err = codec.Encode(&b)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := writer.Write(content); err != nil {
+		writer.Close()
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	return b.Bytes(), nil
+}
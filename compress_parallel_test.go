@@ -0,0 +1,76 @@
+package binclude
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func testFileSystemForCompress() *FileSystem {
+	return &FileSystem{Files: Files{
+		"small.txt": {Filename: "small.txt", Mode: 0644, Content: []byte("hi")},
+		"a.txt":     {Filename: "a.txt", Mode: 0644, Content: bytes.Repeat([]byte("a"), 4096)},
+		"b.txt":     {Filename: "b.txt", Mode: 0644, Content: bytes.Repeat([]byte("b"), 8192)},
+		"c.txt":     {Filename: "c.txt", Mode: 0644, Content: bytes.Repeat([]byte("c"), 2048)},
+	}}
+}
+
+// TestCompressWithOptionsRoundTrip checks that every built-in codec produces
+// content that decodes back to the original through Open, across a worker
+// pool with more than one goroutine, for each registered codec.
+func TestCompressWithOptionsRoundTrip(t *testing.T) {
+	for _, algo := range []Compression{Gzip, Zstd, Brotli, Deflate} {
+		algo := algo
+		t.Run(builtinCompressionNames[algo], func(t *testing.T) {
+			fsys := testFileSystemForCompress()
+			want := make(map[string][]byte, len(fsys.Files))
+			for name, file := range fsys.Files {
+				want[name] = append([]byte(nil), file.Content...)
+			}
+
+			if err := fsys.CompressWithOptions(algo, CompressOptions{Concurrency: 3}); err != nil {
+				t.Fatalf("CompressWithOptions: %v", err)
+			}
+
+			for name, content := range want {
+				file := fsys.Files[name]
+				if file.Compression != algo {
+					t.Errorf("%s: Compression = %v, want %v", name, file.Compression, algo)
+				}
+				if file.UncompressedSize != int64(len(content)) {
+					t.Errorf("%s: UncompressedSize = %d, want %d", name, file.UncompressedSize, len(content))
+				}
+
+				f, err := fsys.Open(name)
+				if err != nil {
+					t.Fatalf("%s: Open: %v", name, err)
+				}
+				got, err := ioutil.ReadAll(f)
+				f.Close()
+				if err != nil {
+					t.Fatalf("%s: ReadAll: %v", name, err)
+				}
+				if !bytes.Equal(got, content) {
+					t.Errorf("%s: round-tripped content does not match original", name)
+				}
+			}
+		})
+	}
+}
+
+// TestCompressWithOptionsMinSize checks that files smaller than MinSize are
+// left uncompressed.
+func TestCompressWithOptionsMinSize(t *testing.T) {
+	fsys := testFileSystemForCompress()
+
+	if err := fsys.CompressWithOptions(Gzip, CompressOptions{MinSize: 4096}); err != nil {
+		t.Fatalf("CompressWithOptions: %v", err)
+	}
+
+	if fsys.Files["small.txt"].Compression != None {
+		t.Error("small.txt should have been skipped by MinSize, but was compressed")
+	}
+	if fsys.Files["b.txt"].Compression != Gzip {
+		t.Error("b.txt is larger than MinSize and should have been compressed")
+	}
+}
@@ -0,0 +1,123 @@
+package binclude
+
+import (
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+)
+
+// check that FileSystem implements the standard io/fs interfaces, so that a
+// bincluded tree can be handed to http.FS, template.ParseFS and any other
+// library that consumes fs.FS without a wrapper type.
+var (
+	_ fs.FS         = new(FileSystem)
+	_ fs.ReadDirFS  = new(FileSystem)
+	_ fs.StatFS     = new(FileSystem)
+	_ fs.ReadFileFS = new(FileSystem)
+	_ fs.GlobFS     = new(FileSystem)
+	_ fs.SubFS      = new(FileSystem)
+)
+
+// Glob returns the names of all files matching pattern, using the same
+// syntax as path.Match. Implements fs.GlobFS.
+func (fsys *FileSystem) Glob(pattern string) ([]string, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+
+	var matches []string
+	for name := range fsys.Files {
+		ok, err := path.Match(pattern, name)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			matches = append(matches, name)
+		}
+	}
+
+	sort.Strings(matches)
+	return matches, nil
+}
+
+// Sub returns an fs.FS corresponding to the subtree rooted at dir: fsys
+// itself for ".", otherwise a view that resolves names relative to dir.
+// Implements fs.SubFS.
+func (fsys *FileSystem) Sub(dir string) (fs.FS, error) {
+	if dir == "." {
+		return fsys, nil
+	}
+
+	info, err := fsys.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, &fs.PathError{Op: "sub", Path: dir, Err: fs.ErrInvalid}
+	}
+
+	return &subFS{fsys: fsys, prefix: dir}, nil
+}
+
+// subFS implements fs.FS, fs.ReadDirFS, fs.StatFS, fs.ReadFileFS and
+// fs.GlobFS by rewriting paths relative to prefix before delegating to fsys,
+// so callers can consume it as if prefix were the filesystem root.
+type subFS struct {
+	fsys   *FileSystem
+	prefix string
+}
+
+func (s *subFS) full(name string) string {
+	if name == "." {
+		return s.prefix
+	}
+	return path.Join(s.prefix, name)
+}
+
+// Open implements fs.FS.
+func (s *subFS) Open(name string) (fs.File, error) {
+	return s.fsys.Open(s.full(name))
+}
+
+// ReadDir implements fs.ReadDirFS.
+func (s *subFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return s.fsys.ReadDir(s.full(name))
+}
+
+// Stat implements fs.StatFS.
+func (s *subFS) Stat(name string) (fs.FileInfo, error) {
+	return s.fsys.Stat(s.full(name))
+}
+
+// ReadFile implements fs.ReadFileFS.
+func (s *subFS) ReadFile(name string) ([]byte, error) {
+	return s.fsys.ReadFile(s.full(name))
+}
+
+// Glob implements fs.GlobFS.
+func (s *subFS) Glob(pattern string) ([]string, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+
+	prefix := s.prefix + "/"
+	var matches []string
+	for name := range s.fsys.Files {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+
+		rel := strings.TrimPrefix(name, prefix)
+		ok, err := path.Match(pattern, rel)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			matches = append(matches, rel)
+		}
+	}
+
+	sort.Strings(matches)
+	return matches, nil
+}
@@ -0,0 +1,75 @@
+package binclude
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+)
+
+// compressedReader streams a compressed BincludeFile's content through its
+// Compressor without materializing the whole plaintext up front. It is
+// created fresh for every FileSystem.Open call and only ever reads
+// file.Content, never writing to the shared BincludeFile, so concurrent Opens
+// of the same path each get their own decode state. Compressed streams can't be seeked
+// natively, so Seek decodes the full plaintext into memory on first use and
+// serves every call after that from the cache.
+type compressedReader struct {
+	file  *BincludeFile
+	codec Compressor
+	dec   io.ReadCloser // streaming decoder, nil once cached
+	pos   int64         // bytes read through dec so far
+
+	cached *bytes.Reader // populated lazily on first Seek
+}
+
+// Read implements the io.Reader interface.
+func (r *compressedReader) Read(p []byte) (int, error) {
+	if r.cached != nil {
+		return r.cached.Read(p)
+	}
+
+	n, err := r.dec.Read(p)
+	r.pos += int64(n)
+	return n, err
+}
+
+// Seek implements the io.Seeker interface.
+func (r *compressedReader) Seek(offset int64, whence int) (int64, error) {
+	if r.cached == nil {
+		if err := r.cacheAll(); err != nil {
+			return 0, err
+		}
+	}
+	return r.cached.Seek(offset, whence)
+}
+
+// Close implements the io.Closer interface.
+func (r *compressedReader) Close() error {
+	if r.dec != nil {
+		return r.dec.Close()
+	}
+	return nil
+}
+
+// cacheAll decodes the file's content from scratch and positions the cached
+// reader at r.pos, so reads that already happened through the streaming
+// decoder keep their place once Seek switches over to the cache.
+func (r *compressedReader) cacheAll() error {
+	dec, err := r.codec.Decode(bytes.NewReader(r.file.Content))
+	if err != nil {
+		return err
+	}
+
+	content, err := ioutil.ReadAll(dec)
+	dec.Close()
+	if err != nil {
+		return err
+	}
+
+	r.dec.Close()
+	r.dec = nil
+
+	r.cached = bytes.NewReader(content)
+	_, err = r.cached.Seek(r.pos, io.SeekStart)
+	return err
+}
@@ -0,0 +1,110 @@
+package binclude
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+func testFileSystemForLazy() (*FileSystem, []byte) {
+	original := []byte("the quick brown fox jumps over the lazy dog, repeated for good measure")
+	fsys := &FileSystem{Files: Files{
+		"f.txt": {Filename: "f.txt", Mode: 0644, Content: append([]byte(nil), original...)},
+	}}
+	if err := fsys.Compress(Gzip); err != nil {
+		panic(err)
+	}
+	return fsys, original
+}
+
+// TestCompressedReaderPartialReadThenSeek checks that Seek(0, io.SeekCurrent)
+// after a partial streaming read reports the position already consumed, and
+// that reads continue from there once cacheAll switches to the cached
+// buffer - the scenario lazy.go's cacheAll positions r.cached for.
+func TestCompressedReaderPartialReadThenSeek(t *testing.T) {
+	fsys, original := testFileSystemForLazy()
+
+	f, err := fsys.Open("f.txt")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer f.Close()
+
+	seeker, ok := f.(io.Seeker)
+	if !ok {
+		t.Fatal("Open did not return an io.Seeker")
+	}
+
+	head := make([]byte, 10)
+	n, err := f.Read(head)
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if n != len(head) {
+		t.Fatalf("Read returned %d bytes, want %d", n, len(head))
+	}
+	if !bytes.Equal(head, original[:10]) {
+		t.Fatalf("Read = %q, want %q", head, original[:10])
+	}
+
+	pos, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		t.Fatalf("Seek: %v", err)
+	}
+	if pos != int64(len(head)) {
+		t.Fatalf("Seek(0, io.SeekCurrent) = %d, want %d", pos, len(head))
+	}
+
+	rest, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if !bytes.Equal(rest, original[len(head):]) {
+		t.Fatalf("bytes read after Seek = %q, want %q", rest, original[len(head):])
+	}
+}
+
+// TestOpenConcurrentHandlesAreIndependent checks that two Open calls for the
+// same path get their own reader and read position instead of aliasing the
+// shared *BincludeFile, which would let one handle's Read/Seek interfere
+// with the other's.
+func TestOpenConcurrentHandlesAreIndependent(t *testing.T) {
+	fsys, original := testFileSystemForLazy()
+
+	a, err := fsys.Open("f.txt")
+	if err != nil {
+		t.Fatalf("Open a: %v", err)
+	}
+	defer a.Close()
+
+	b, err := fsys.Open("f.txt")
+	if err != nil {
+		t.Fatalf("Open b: %v", err)
+	}
+	defer b.Close()
+
+	aHead := make([]byte, 5)
+	if _, err := io.ReadFull(a, aHead); err != nil {
+		t.Fatalf("a.Read: %v", err)
+	}
+	if !bytes.Equal(aHead, original[:5]) {
+		t.Fatalf("a read = %q, want %q", aHead, original[:5])
+	}
+
+	bAll, err := ioutil.ReadAll(b)
+	if err != nil {
+		t.Fatalf("b.ReadAll: %v", err)
+	}
+	if !bytes.Equal(bAll, original) {
+		t.Fatalf("b, opened independently of a, should read the whole file from the start; got %q", bAll)
+	}
+
+	aRest, err := ioutil.ReadAll(a)
+	if err != nil {
+		t.Fatalf("a.ReadAll: %v", err)
+	}
+	if !bytes.Equal(aRest, original[5:]) {
+		t.Fatalf("a should resume where its own Read left off, unaffected by b; got %q, want %q", aRest, original[5:])
+	}
+}